@@ -0,0 +1,176 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// call is a single request queued on a Batch, waiting to be sent.
+type call struct {
+	method string
+	params []interface{}
+	id     uint64
+	result interface{}
+}
+
+// Batch queues several confd calls and sends them as a single JSON-RPC
+// array request, demultiplexing the responses back into the result
+// pointers passed to Add. Create one with Conn.Batch.
+type Batch struct {
+	conn  *Conn
+	calls []*call
+}
+
+// Batch returns a new, empty Batch bound to c.
+func (c *Conn) Batch() *Batch {
+	return &Batch{conn: c}
+}
+
+// Add queues method to be sent when Do is called, decoding its result into
+// result (which may be nil, as with Request). Params are passed through
+// unchanged.
+func (b *Batch) Add(method string, result interface{}, params ...interface{}) {
+	b.calls = append(b.calls, &call{
+		method: method,
+		params: params,
+		id:     b.conn.nextID(),
+		result: result,
+	})
+}
+
+// batchRequest is the wire representation of one call within a batch.
+type batchRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params,omitempty"`
+	ID     uint64        `json:"id"`
+}
+
+// batchResponse is the wire representation of one call's response within a
+// batch; it is decoded generically and matched back to its call by ID.
+type batchResponse struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *batchRPCError  `json:"error,omitempty"`
+}
+
+// batchRPCError is the error envelope confd returns for a failed sub-call.
+type batchRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// BatchError reports the sub-calls of a Batch that failed; calls that
+// succeeded already have their result decoded despite the error being
+// returned.
+type BatchError struct {
+	Errors []error
+}
+
+// Error implements error.
+func (e *BatchError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("confd: batch: %d calls failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Do sends every call queued with Add as a single JSON-RPC array request
+// and decodes each response into its result pointer. A failing sub-call is
+// collected into a *BatchError rather than aborting the whole batch; calls
+// that succeeded still have their result populated.
+func (b *Batch) Do(ctx context.Context) (err error) {
+	if len(b.calls) == 0 {
+		return nil
+	}
+
+	ctx, cancel := b.conn.withDeadline(ctx)
+	defer cancel()
+
+	if err := b.conn.connect(ctx); err != nil {
+		return err
+	}
+
+	reqs := make([]batchRequest, len(b.calls))
+	for i, c := range b.calls {
+		reqs[i] = batchRequest{Method: c.method, Params: c.params, ID: c.id}
+	}
+	body, err := json.Marshal(reqs)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, "http://"+b.conn.URL.Host+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq = httpReq.WithContext(ctx)
+
+	start := time.Now()
+	id := b.conn.nextID()
+	b.conn.log(LevelDebug, "batch request", map[string]interface{}{"size": len(b.calls)})
+	b.conn.notifyRequestStart(id, "batch")
+	defer func() {
+		b.conn.notifyRequestEnd(id, "batch", err, time.Since(start))
+	}()
+
+	b.conn.requestMu.Lock()
+	resp, err := b.conn.Transport.RoundTrip(httpReq)
+	b.conn.requestMu.Unlock()
+	if err != nil {
+		_ = b.conn.Transport.Close() // ignore close errors
+		b.conn.notifyClose()
+		b.conn.log(LevelError, "batch request failed", map[string]interface{}{"size": len(b.calls), "error": err.Error()})
+		return err
+	}
+	defer resp.Body.Close()
+
+	var raw []batchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return err
+	}
+
+	byID := make(map[uint64]batchResponse, len(raw))
+	for _, r := range raw {
+		byID[r.ID] = r
+	}
+
+	var errs []error
+	for _, c := range b.calls {
+		r, ok := byID[c.id]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s (id %d): no response", c.method, c.id))
+			continue
+		}
+		if r.Error != nil {
+			errs = append(errs, fmt.Errorf("%s (id %d): %s", c.method, c.id, r.Error.Message))
+			continue
+		}
+		if c.result != nil && len(r.Result) > 0 {
+			if err := json.Unmarshal(r.Result, c.result); err != nil {
+				errs = append(errs, fmt.Errorf("%s (id %d): %w", c.method, c.id, err))
+			}
+		}
+	}
+
+	b.conn.log(LevelDebug, "batch request completed", map[string]interface{}{
+		"size":        len(b.calls),
+		"failed":      len(errs),
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+
+	if len(errs) > 0 {
+		return &BatchError{Errors: errs}
+	}
+	return nil
+}
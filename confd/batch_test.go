@@ -0,0 +1,131 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// fakeTransport is a Transport whose RoundTrip is driven by a test-supplied
+// handler; it reports itself as already connected so Conn.connect skips the
+// "new"/"get_SID" handshake and Batch.Do's RoundTrip call is the only thing
+// exercised.
+type fakeTransport struct {
+	handler func(*http.Request) (*http.Response, error)
+}
+
+func (t *fakeTransport) Connect(u *url.URL) error { return nil }
+func (t *fakeTransport) IsConnected() bool        { return true }
+func (t *fakeTransport) Close() error             { return nil }
+func (t *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.handler(req)
+}
+
+func newBatchTestConn(t *testing.T, handler func([]batchRequest) []batchResponse) *Conn {
+	t.Helper()
+	u, err := url.Parse("http://127.0.0.1:4472/")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	return &Conn{
+		URL: u,
+		Transport: &fakeTransport{handler: func(req *http.Request) (*http.Response, error) {
+			var reqs []batchRequest
+			if err := json.NewDecoder(req.Body).Decode(&reqs); err != nil {
+				t.Fatalf("decode batch request: %v", err)
+			}
+			body, err := json.Marshal(handler(reqs))
+			if err != nil {
+				t.Fatalf("marshal batch response: %v", err)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader(body)),
+			}, nil
+		}},
+	}
+}
+
+func TestBatchDoSuccess(t *testing.T) {
+	conn := newBatchTestConn(t, func(reqs []batchRequest) []batchResponse {
+		resps := make([]batchResponse, len(reqs))
+		for i, r := range reqs {
+			resps[i] = batchResponse{ID: r.ID, Result: json.RawMessage(`"` + r.Method + "-result" + `"`)}
+		}
+		return resps
+	})
+
+	b := conn.Batch()
+	var foo, bar string
+	b.Add("foo", &foo)
+	b.Add("bar", &bar)
+
+	if err := b.Do(context.Background()); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if foo != "foo-result" {
+		t.Errorf("foo = %q, want %q", foo, "foo-result")
+	}
+	if bar != "bar-result" {
+		t.Errorf("bar = %q, want %q", bar, "bar-result")
+	}
+}
+
+func TestBatchDoPartialFailure(t *testing.T) {
+	conn := newBatchTestConn(t, func(reqs []batchRequest) []batchResponse {
+		resps := make([]batchResponse, len(reqs))
+		for i, r := range reqs {
+			if r.Method == "fail" {
+				resps[i] = batchResponse{ID: r.ID, Error: &batchRPCError{Code: 1, Message: "boom"}}
+				continue
+			}
+			resps[i] = batchResponse{ID: r.ID, Result: json.RawMessage(`"ok"`)}
+		}
+		return resps
+	})
+
+	b := conn.Batch()
+	var ok, bad string
+	b.Add("succeeds", &ok)
+	b.Add("fail", &bad)
+
+	err := b.Do(context.Background())
+	berr, isBatchErr := err.(*BatchError)
+	if !isBatchErr {
+		t.Fatalf("Do error = %v (%T), want *BatchError", err, err)
+	}
+	if len(berr.Errors) != 1 {
+		t.Fatalf("len(Errors) = %d, want 1", len(berr.Errors))
+	}
+	if ok != "ok" {
+		t.Errorf("ok = %q, want %q, want the successful call's result to still be decoded", ok, "ok")
+	}
+}
+
+func TestBatchDoMissingResponse(t *testing.T) {
+	conn := newBatchTestConn(t, func(reqs []batchRequest) []batchResponse {
+		// Respond to nothing, simulating confd dropping a sub-call's entry.
+		return nil
+	})
+
+	b := conn.Batch()
+	var result string
+	b.Add("missing", &result)
+
+	err := b.Do(context.Background())
+	berr, isBatchErr := err.(*BatchError)
+	if !isBatchErr {
+		t.Fatalf("Do error = %v (%T), want *BatchError", err, err)
+	}
+	if len(berr.Errors) != 1 {
+		t.Fatalf("len(Errors) = %d, want 1", len(berr.Errors))
+	}
+}
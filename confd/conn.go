@@ -5,29 +5,35 @@
 package confd
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"log"
 	"net/url"
-	"regexp"
+	"os"
 	"strings"
 	"sync"
-)
+	"time"
 
-var safePasswordRegexp = regexp.MustCompile(`password":"[^"]+"`)
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
 
-// BUG(threez) It currently requires to connect directly to the confd database.
-// This can be done by connecting through an ssh tunnel and forward the port
-// 4472, e.g.:
-//
-//      ssh -L 4472:127.0.0.1:4472 root@utm
+// sshKnownHostsEnvVar names the environment variable NewConn consults for a
+// known_hosts file to verify the SSH host key against when using the
+// ssh+confd:// scheme. Unset, NewConn falls back to
+// ssh.InsecureIgnoreHostKey, which is exposed to MITM attacks.
+const sshKnownHostsEnvVar = "CONFD_SSH_KNOWN_HOSTS"
 
 // Conn is the confd connection object
 type Conn struct {
-	URL     *url.URL    // URL that the connection connects to
-	Logger  *log.Logger // Logger if specified, will log confd actions
-	Options *Options    // Options represent connection options
-	id      struct {
+	URL           *url.URL        // URL that the connection connects to
+	Logger        Logger          // Logger if specified, will log confd actions
+	SensitiveKeys map[string]bool // field names redacted from Logger output, defaults to DefaultSensitiveKeys
+	Options       *Options        // Options represent connection options
+	Timeout       time.Duration   // per-call deadline used when a call's context carries none, defaults to defaultTimeout
+	RetryPolicy   *RetryPolicy    // retries for ErrEmptyResponse and transport errors, nil disables retries
+	Observers     []Observer      // notified of request/connect/close lifecycle events, e.g. for metrics or tracing
+	id            struct {
 		Value      uint64 // json rpc counter
 		sync.Mutex        // prevent double counting
 	}
@@ -37,13 +43,30 @@ type Conn struct {
 	requestMu sync.Mutex // prevent concurrent confd access
 }
 
-// NewConn creates a new confd connection (is not acually connecting)
+// NewConn creates a new confd connection (is not acually connecting). URLs
+// with the `ssh+confd://user@host:22/` scheme are tunneled over SSH (see
+// SSHTransport) instead of connecting directly to the confd socket; the
+// password, if any, is used as the SSH password authentication method. The
+// host key is verified against the known_hosts file named by
+// CONFD_SSH_KNOWN_HOSTS, if set; otherwise the host key is not verified at
+// all, which is only appropriate for trusted networks, and a warning is
+// logged each time NewConn creates such a connection. Use NewSSHConn directly to supply a stronger
+// ssh.AuthMethod or ssh.HostKeyCallback.
 func NewConn(URL string) (conn *Conn, err error) {
 	u, err := url.Parse(URL)
 	if err != nil {
 		return
 	}
 
+	if u.Scheme == "ssh+confd" {
+		password, _ := u.User.Password()
+		hostKeyCallback, err := sshHostKeyCallback()
+		if err != nil {
+			return nil, err
+		}
+		return NewSSHConn(u.Host, u.User.Username(), ssh.Password(password), hostKeyCallback)
+	}
+
 	conn = &Conn{
 		URL:       u,
 		Logger:    nil,
@@ -53,6 +76,23 @@ func NewConn(URL string) (conn *Conn, err error) {
 	return
 }
 
+// sshHostKeyCallback returns a callback that verifies the SSH host key
+// against the known_hosts file named by CONFD_SSH_KNOWN_HOSTS, if set. If
+// it isn't set, it returns ssh.InsecureIgnoreHostKey and logs a warning,
+// since the NewConn ssh+confd:// shortcut is otherwise exposed to MITM
+// attacks.
+func sshHostKeyCallback() (ssh.HostKeyCallback, error) {
+	path := os.Getenv(sshKnownHostsEnvVar)
+	if path == "" {
+		log.Printf("confd: WARNING: ssh+confd:// connecting with no %s set, "+
+			"SSH host key is NOT verified (exposed to MITM); set %s to a "+
+			"known_hosts file, or call NewSSHConn directly with your own "+
+			"ssh.HostKeyCallback", sshKnownHostsEnvVar, sshKnownHostsEnvVar)
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	return knownhosts.New(path)
+}
+
 // NewAnonymousConn creates a new confd connection (is not acually connecting)
 // to http://127.0.0.1:4472/ (Local Connection)
 func NewAnonymousConn() (conn *Conn) {
@@ -83,20 +123,37 @@ func NewUserConn(username, password, ip string) (conn *Conn) {
 
 // SimpleRequest sends a simple request (untyped response) to the confd
 func (c *Conn) SimpleRequest(method string, params ...interface{}) (interface{}, error) {
+	return c.SimpleRequestContext(context.Background(), method, params...)
+}
+
+// SimpleRequestContext is SimpleRequest with a context that can cancel the
+// call, carry a deadline overriding Timeout, and bound any retries.
+func (c *Conn) SimpleRequestContext(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
 	result := new(interface{})
-	err := c.Request(method, result, params...)
+	err := c.RequestContext(ctx, method, result, params...)
 	return result, err
 }
 
 // Request allows to send request with typed (parsed with json) responses
-func (c *Conn) Request(method string, result interface{}, params ...interface{}) (err error) {
+func (c *Conn) Request(method string, result interface{}, params ...interface{}) error {
+	return c.RequestContext(context.Background(), method, result, params...)
+}
+
+// RequestContext is Request with a context that can cancel the call, carry
+// a deadline overriding Timeout, and bound any retries performed under
+// RetryPolicy. If ctx carries no deadline, one is derived from Timeout (or
+// defaultTimeout, if Timeout is zero).
+func (c *Conn) RequestContext(ctx context.Context, method string, result interface{}, params ...interface{}) (err error) {
+	ctx, cancel := c.withDeadline(ctx)
+	defer cancel()
+
 	// make sure we have a connection to the server
-	err = c.Connect()
+	err = c.connect(ctx)
 	if err != nil {
 		return
 	}
 
-	err = c.request(method, result, params...)
+	err = c.requestRetrying(ctx, method, result, params...)
 
 	// automatic error handling
 	if err == ErrEmptyResponse || err == ErrReturnCode {
@@ -110,47 +167,107 @@ func (c *Conn) Request(method string, result interface{}, params ...interface{})
 	}
 
 	if err != nil {
-		c.logf("Error: %v", err)
+		c.log(LevelError, "request error", map[string]interface{}{"method": method, "error": err.Error()})
 	}
 	return
 }
 
 // Connect creates a new confd session by calling new and get_SID confd calls.
 // It is preffered to not use the call and create sessions if requests are made
-func (c *Conn) Connect() (err error) {
+func (c *Conn) Connect() error {
+	return c.connect(context.Background())
+}
+
+func (c *Conn) connect(ctx context.Context) (err error) {
 	if c.Transport.IsConnected() {
 		return
 	}
 	c.sessionMu.Lock()
 	defer c.sessionMu.Unlock()
-	c.logf("Connect to %s", c.safeURL())
+	c.log(LevelInfo, "connect", map[string]interface{}{"url": c.safeURL()})
 	err = c.Transport.Connect(c.URL)
 	if err != nil {
-		c.logf("Unable to connect %s", err)
+		c.log(LevelError, "connect failed", map[string]interface{}{"url": c.safeURL(), "error": err.Error()})
 		return
 	}
-	err = c.request("new", nil, c.Options)
+	c.notifyConnect()
+	err = c.requestRetrying(ctx, "new", nil, c.Options)
 	if err == nil && c.Options.SID == nil {
 		// if we got a sid we will use it next time
-		err = c.request("get_SID", &c.Options.SID)
+		err = c.requestRetrying(ctx, "get_SID", &c.Options.SID)
 	}
 	if err != nil {
-		c.logf("Unable to create session %v", err)
+		c.log(LevelError, "create session failed", map[string]interface{}{"error": err.Error()})
 	}
 	return
 }
 
-func (c *Conn) request(method string, result interface{}, params ...interface{}) error {
+// withDeadline ensures ctx carries a deadline, deriving one from Timeout
+// (or defaultTimeout, if Timeout is zero) when it doesn't already.
+func (c *Conn) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// requestRetrying calls request, retrying transient failures according to
+// RetryPolicy (no retries if it is nil) with exponential backoff and full
+// jitter, bailing out early if ctx is done.
+func (c *Conn) requestRetrying(ctx context.Context, method string, result interface{}, params ...interface{}) error {
+	policy := c.RetryPolicy
+	if policy == nil {
+		return c.request(ctx, method, result, params...)
+	}
+
+	var err error
+	for attempt := 0; attempt <= policy.MaxRetries; attempt++ {
+		err = c.request(ctx, method, result, params...)
+		if err == nil || !isRetryableError(err) {
+			return err
+		}
+		if attempt == policy.MaxRetries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(policy.backoff(attempt)):
+		}
+	}
+	return err
+}
+
+func (c *Conn) request(ctx context.Context, method string, result interface{}, params ...interface{}) (err error) {
+	start := time.Now()
+	id := c.nextID()
+	c.notifyRequestStart(id, method)
+	defer func() {
+		c.notifyRequestEnd(id, method, err, time.Since(start))
+	}()
+
 	// request
-	r, err := newRequest(method, params, c.nextID())
+	r, err := newRequest(method, params, id)
 	if err != nil {
 		return err
 	}
-	c.logf("=> %s", r.String())
 	req, err := r.HTTP(c.URL.Host)
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
+
+	fields := map[string]interface{}{
+		"method": method,
+		"id":     id,
+		"url":    c.URL.Host,
+		"params": params,
+	}
+	c.log(LevelDebug, "request", fields)
 
 	// send request
 	c.requestMu.Lock()
@@ -159,20 +276,32 @@ func (c *Conn) request(method string, result interface{}, params ...interface{})
 	if err != nil {
 		// send receive operation failed, conenction will be closed
 		_ = c.Transport.Close() // ignore close errors
+		c.notifyClose()
+		fields["duration_ms"] = time.Since(start).Milliseconds()
+		fields["error"] = err.Error()
+		c.log(LevelError, "request failed", fields)
 		return err
 	}
 
 	// decode response
 	respObj, err := newResponse(resp.Body)
 	if err != nil {
+		fields["duration_ms"] = time.Since(start).Milliseconds()
+		fields["error"] = err.Error()
+		c.log(LevelError, "request failed", fields)
 		return err
 	}
 	err = respObj.Decode(result, method != "get_SID")
 	if err != nil {
+		fields["duration_ms"] = time.Since(start).Milliseconds()
+		fields["error"] = err.Error()
+		c.log(LevelError, "request failed", fields)
 		return err
 	}
 
-	c.logf("<= %v", respObj)
+	fields["duration_ms"] = time.Since(start).Milliseconds()
+	fields["result"] = result
+	c.log(LevelDebug, "request completed", fields)
 
 	return nil
 }
@@ -182,20 +311,22 @@ func (c *Conn) Close() (err error) {
 	if c.Transport.IsConnected() {
 		c.sessionMu.Lock()
 		defer c.sessionMu.Unlock()
-		c.logf("Disconnect from %s", c.safeURL())
-		_ = c.request("detach", nil) // ignore if we can't detach
-		_ = c.Transport.Close()      // ignore close errors
+		c.log(LevelInfo, "disconnect", map[string]interface{}{"url": c.safeURL()})
+		_ = c.request(context.Background(), "detach", nil) // ignore if we can't detach
+		// a failed detach has already closed the transport and notified
+		if c.Transport.IsConnected() {
+			_ = c.Transport.Close() // ignore close errors
+			c.notifyClose()
+		}
 	}
 	return
 }
 
-// logf takes care of logging if a logger is present and removes password
-// information of a given form
-func (c *Conn) logf(format string, args ...interface{}) {
+// log emits msg with fields to Logger, if one is set, redacting any field
+// reachable from a key in SensitiveKeys (or DefaultSensitiveKeys, if unset).
+func (c *Conn) log(level Level, msg string, fields map[string]interface{}) {
 	if c.Logger != nil {
-		str := fmt.Sprintf(format, args...)
-		str = safePasswordRegexp.ReplaceAllString(str, `password":"********"`)
-		c.Logger.Print(str)
+		c.Logger.Log(level, msg, redactFields(fields, c.SensitiveKeys))
 	}
 }
 
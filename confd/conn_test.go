@@ -0,0 +1,27 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import "testing"
+
+func TestSSHHostKeyCallbackFallsBackWithoutEnv(t *testing.T) {
+	t.Setenv(sshKnownHostsEnvVar, "")
+
+	cb, err := sshHostKeyCallback()
+	if err != nil {
+		t.Fatalf("sshHostKeyCallback: %v", err)
+	}
+	if cb == nil {
+		t.Fatal("sshHostKeyCallback returned a nil callback")
+	}
+}
+
+func TestSSHHostKeyCallbackFromKnownHostsFile(t *testing.T) {
+	t.Setenv(sshKnownHostsEnvVar, "testdata/does-not-exist")
+
+	if _, err := sshHostKeyCallback(); err == nil {
+		t.Fatal("sshHostKeyCallback with a missing known_hosts file, want error")
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package logrus adapts a *logrus.Logger to the confd.Logger interface.
+package logrus
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/threez/go-confd/confd"
+)
+
+// Adapter adapts a *logrus.Logger (or any value implementing FieldLogger)
+// so it can be used as a confd.Logger.
+type Adapter struct {
+	Logger logrus.FieldLogger
+}
+
+// New wraps l so it can be set as Conn.Logger.
+func New(l logrus.FieldLogger) *Adapter {
+	return &Adapter{Logger: l}
+}
+
+// Log implements confd.Logger.
+func (a *Adapter) Log(level confd.Level, msg string, fields map[string]interface{}) {
+	entry := a.Logger.WithFields(logrus.Fields(fields))
+	switch level {
+	case confd.LevelDebug:
+		entry.Debug(msg)
+	case confd.LevelInfo:
+		entry.Info(msg)
+	case confd.LevelWarn:
+		entry.Warn(msg)
+	case confd.LevelError:
+		entry.Error(msg)
+	default:
+		entry.Info(msg)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package slog adapts a *slog.Logger to the confd.Logger interface.
+package slog
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/threez/go-confd/confd"
+)
+
+// Adapter adapts a *slog.Logger so it can be used as a confd.Logger.
+type Adapter struct {
+	Logger *slog.Logger
+}
+
+// New wraps l so it can be set as Conn.Logger.
+func New(l *slog.Logger) *Adapter {
+	return &Adapter{Logger: l}
+}
+
+// Log implements confd.Logger.
+func (a *Adapter) Log(level confd.Level, msg string, fields map[string]interface{}) {
+	args := make([]interface{}, 0, len(fields)*2)
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+	a.Logger.Log(context.Background(), slogLevel(level), msg, args...)
+}
+
+func slogLevel(level confd.Level) slog.Level {
+	switch level {
+	case confd.LevelDebug:
+		return slog.LevelDebug
+	case confd.LevelInfo:
+		return slog.LevelInfo
+	case confd.LevelWarn:
+		return slog.LevelWarn
+	case confd.LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zap adapts a *zap.SugaredLogger to the confd.Logger interface.
+package zap
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/threez/go-confd/confd"
+)
+
+// Adapter adapts a *zap.SugaredLogger so it can be used as a confd.Logger.
+type Adapter struct {
+	Logger *zap.SugaredLogger
+}
+
+// New wraps l so it can be set as Conn.Logger.
+func New(l *zap.SugaredLogger) *Adapter {
+	return &Adapter{Logger: l}
+}
+
+// Log implements confd.Logger.
+func (a *Adapter) Log(level confd.Level, msg string, fields map[string]interface{}) {
+	args := make([]interface{}, 0, len(fields)*2)
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+	switch level {
+	case confd.LevelDebug:
+		a.Logger.Debugw(msg, args...)
+	case confd.LevelInfo:
+		a.Logger.Infow(msg, args...)
+	case confd.LevelWarn:
+		a.Logger.Warnw(msg, args...)
+	case confd.LevelError:
+		a.Logger.Errorw(msg, args...)
+	default:
+		a.Logger.Infow(msg, args...)
+	}
+}
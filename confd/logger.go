@@ -0,0 +1,149 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// Level identifies the severity of a logged event.
+type Level int
+
+// Log levels used by Conn, in increasing order of severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the lower-case name of the level, as used by most
+// structured logging libraries.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Logger is a pluggable structured logging sink for Conn. Implementations
+// are expected to be safe for concurrent use. See the confd/logadapter
+// subpackages for ready-made adapters to logrus, zap and slog.
+type Logger interface {
+	Log(level Level, msg string, fields map[string]interface{})
+}
+
+// DefaultSensitiveKeys lists the field names that are redacted from logged
+// fields, such as params and result, by default. Keys are matched
+// case-insensitively at any depth of nested maps, slices and structs; the
+// set's own keys must be lower-case. String values, such as url, are not
+// parsed. Set Conn.SensitiveKeys to override.
+var DefaultSensitiveKeys = map[string]bool{
+	"password": true,
+	"passwd":   true,
+	"sid":      true,
+	"secret":   true,
+}
+
+// sensitiveResultMethods lists confd calls whose result is sensitive in its
+// entirety, e.g. a bare scalar with no enclosing key for DefaultSensitiveKeys
+// to match against. "method" is matched case-insensitively.
+var sensitiveResultMethods = map[string]bool{
+	"get_sid": true,
+}
+
+// StdLogger adapts a standard library *log.Logger to the Logger interface,
+// preserving the behavior of the previous, unstructured Conn.Logger field.
+type StdLogger struct {
+	*log.Logger
+}
+
+// NewStdLogger wraps l so it can be used as a Conn.Logger.
+func NewStdLogger(l *log.Logger) *StdLogger {
+	return &StdLogger{l}
+}
+
+// Log implements Logger.
+func (s *StdLogger) Log(level Level, msg string, fields map[string]interface{}) {
+	s.Logger.Printf("%s %s %v", level, msg, fields)
+}
+
+// redactFields returns a copy of fields with any value reachable from a
+// sensitive key replaced by "********". Unlike the regular expression it
+// replaces, it walks the actual object graph (via a JSON round trip) so it
+// catches sensitive keys regardless of nesting or serialized form.
+func redactFields(fields map[string]interface{}, sensitive map[string]bool) map[string]interface{} {
+	if sensitive == nil {
+		sensitive = DefaultSensitiveKeys
+	}
+	safe := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if sensitive[strings.ToLower(k)] {
+			safe[k] = "********"
+			continue
+		}
+		safe[k] = redactValue(v, sensitive)
+	}
+	if method, ok := fields["method"].(string); ok && sensitiveResultMethods[strings.ToLower(method)] {
+		if _, ok := safe["result"]; ok {
+			safe["result"] = "********"
+		}
+	}
+	return safe
+}
+
+// redactValue walks v looking for sensitive keys. Values that aren't
+// already a generic map/slice (structs, typed params, ...) are first
+// round-tripped through encoding/json so the same redaction logic applies
+// regardless of the concrete Go type.
+func redactValue(v interface{}, sensitive map[string]bool) interface{} {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return redactGeneric(v, sensitive)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return v
+	}
+	return redactGeneric(generic, sensitive)
+}
+
+func redactGeneric(v interface{}, sensitive map[string]bool) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			if sensitive[strings.ToLower(k)] {
+				out[k] = "********"
+				continue
+			}
+			out[k] = redactValue(val, sensitive)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, val := range t {
+			out[i] = redactValue(val, sensitive)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import "testing"
+
+func TestRedactFieldsNestedStruct(t *testing.T) {
+	type options struct {
+		Username string
+		Password string
+		SID      string
+	}
+
+	fields := map[string]interface{}{
+		"method": "new",
+		"params": []interface{}{&options{Username: "root", Password: "supersecret", SID: "deadbeef-sid"}},
+	}
+
+	safe := redactFields(fields, nil)
+
+	params, ok := safe["params"].([]interface{})
+	if !ok || len(params) != 1 {
+		t.Fatalf("params = %#v, want a single-element slice", safe["params"])
+	}
+	opt, ok := params[0].(map[string]interface{})
+	if !ok {
+		t.Fatalf("params[0] = %#v, want a map", params[0])
+	}
+	if opt["Password"] != "********" {
+		t.Errorf("Password = %v, want redacted", opt["Password"])
+	}
+	if opt["SID"] != "********" {
+		t.Errorf("SID = %v, want redacted", opt["SID"])
+	}
+	if opt["Username"] != "root" {
+		t.Errorf("Username = %v, want untouched", opt["Username"])
+	}
+}
+
+func TestRedactFieldsSensitiveResultMethod(t *testing.T) {
+	sid := "deadbeef-sid"
+	fields := map[string]interface{}{
+		"method": "get_SID",
+		"result": &sid,
+	}
+
+	safe := redactFields(fields, nil)
+
+	if safe["result"] != "********" {
+		t.Errorf("result = %v, want redacted", safe["result"])
+	}
+}
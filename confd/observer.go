@@ -0,0 +1,52 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import "time"
+
+// Observer receives lifecycle callbacks for a Conn, making it possible to
+// expose metrics or traces without every caller re-implementing timing
+// around Request. See the confd/observer subpackages for ready-made
+// Prometheus and OpenTelemetry observers.
+type Observer interface {
+	// OnRequestStart is called right before a JSON-RPC call is sent. id is
+	// the call's JSON-RPC request id, unique per Conn, which lets an
+	// Observer match a OnRequestEnd call back to the right OnRequestStart
+	// even when the same method is in flight concurrently on one Conn.
+	OnRequestStart(id uint64, method string)
+	// OnRequestEnd is called once a JSON-RPC call completes, successfully
+	// or not, with the time spent since the matching OnRequestStart.
+	OnRequestEnd(id uint64, method string, err error, dur time.Duration)
+	// OnConnect is called once a new underlying transport connection has
+	// been established (before the confd session handshake).
+	OnConnect()
+	// OnClose is called once the underlying transport connection has been
+	// closed.
+	OnClose()
+}
+
+func (c *Conn) notifyRequestStart(id uint64, method string) {
+	for _, o := range c.Observers {
+		o.OnRequestStart(id, method)
+	}
+}
+
+func (c *Conn) notifyRequestEnd(id uint64, method string, err error, dur time.Duration) {
+	for _, o := range c.Observers {
+		o.OnRequestEnd(id, method, err, dur)
+	}
+}
+
+func (c *Conn) notifyConnect() {
+	for _, o := range c.Observers {
+		o.OnConnect()
+	}
+}
+
+func (c *Conn) notifyClose() {
+	for _, o := range c.Observers {
+		o.OnClose()
+	}
+}
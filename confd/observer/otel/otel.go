@@ -0,0 +1,88 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package otel implements a confd.Observer that wraps each request in an
+// OpenTelemetry span.
+package otel
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/threez/go-confd/confd"
+)
+
+// Observer is a confd.Observer that starts a span for every request,
+// carrying rpc.system=jsonrpc, rpc.method and the sanitized confd URL.
+// Because confd.Observer's callbacks don't carry a context, spans are
+// started as roots; wrap Conn.RequestContext calls with your own span if
+// you need requests parented to a caller's trace.
+type Observer struct {
+	tracer  trace.Tracer
+	url     string
+	mu      sync.Mutex
+	pending map[uint64]trace.Span // spans awaiting OnRequestEnd, keyed by request id
+}
+
+// New creates an Observer that emits spans to tracer, tagging every span
+// with the given (already redacted) confd URL.
+func New(tracer trace.Tracer, url string) *Observer {
+	if tracer == nil {
+		tracer = otel.Tracer("github.com/threez/go-confd/confd")
+	}
+	return &Observer{
+		tracer:  tracer,
+		url:     url,
+		pending: make(map[uint64]trace.Span),
+	}
+}
+
+// OnRequestStart implements confd.Observer.
+func (o *Observer) OnRequestStart(id uint64, method string) {
+	_, span := o.tracer.Start(context.Background(), "confd."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "jsonrpc"),
+			attribute.String("rpc.method", method),
+			attribute.String("confd.url", o.url),
+		),
+	)
+	o.mu.Lock()
+	o.pending[id] = span
+	o.mu.Unlock()
+}
+
+// OnRequestEnd implements confd.Observer.
+func (o *Observer) OnRequestEnd(id uint64, method string, err error, dur time.Duration) {
+	o.mu.Lock()
+	span, ok := o.pending[id]
+	if !ok {
+		o.mu.Unlock()
+		return
+	}
+	delete(o.pending, id)
+	o.mu.Unlock()
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+	span.End()
+}
+
+// OnConnect implements confd.Observer.
+func (o *Observer) OnConnect() {}
+
+// OnClose implements confd.Observer.
+func (o *Observer) OnClose() {}
+
+var _ confd.Observer = (*Observer)(nil)
@@ -0,0 +1,41 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package otel
+
+import (
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// TestObserverConcurrentSameMethod guards against attributing the wrong
+// span's status to the wrong call when two requests for the same method are
+// in flight on one Conn at once; spans must be matched by request id, not
+// by method name.
+func TestObserverConcurrentSameMethod(t *testing.T) {
+	tp := trace.NewTracerProvider()
+	o := New(tp.Tracer("test"), "http://example.test")
+
+	o.OnRequestStart(1, "get")
+	o.OnRequestStart(2, "get")
+
+	if len(o.pending) != 2 {
+		t.Fatalf("pending = %d spans, want 2", len(o.pending))
+	}
+
+	o.OnRequestEnd(1, "get", nil, time.Millisecond)
+	if _, ok := o.pending[1]; ok {
+		t.Errorf("request id 1 still pending after its OnRequestEnd")
+	}
+	if _, ok := o.pending[2]; !ok {
+		t.Errorf("request id 2 was removed by request id 1's OnRequestEnd")
+	}
+
+	o.OnRequestEnd(2, "get", nil, time.Millisecond)
+	if len(o.pending) != 0 {
+		t.Errorf("pending = %d spans, want 0", len(o.pending))
+	}
+}
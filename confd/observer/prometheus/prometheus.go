@@ -0,0 +1,86 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package prometheus implements a confd.Observer that exposes request
+// counters, a duration histogram and an active-session gauge as a
+// prometheus.Collector.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/threez/go-confd/confd"
+)
+
+// Observer is a confd.Observer that records Prometheus metrics for every
+// request, connect and close. Register it once with a prometheus.Registerer
+// and attach it to one or more Conn.Observers.
+type Observer struct {
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	activeSessions  prometheus.Gauge
+}
+
+// New creates an Observer. Metric names are fixed
+// (confd_requests_total{method,status}, confd_request_duration_seconds,
+// confd_active_sessions) so dashboards built against one confd client
+// work for any other.
+func New() *Observer {
+	return &Observer{
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "confd_requests_total",
+			Help: "Total number of confd JSON-RPC requests, by method and status.",
+		}, []string{"method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "confd_request_duration_seconds",
+			Help:    "Duration of confd JSON-RPC requests in seconds, by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		activeSessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "confd_active_sessions",
+			Help: "Number of currently connected confd sessions.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (o *Observer) Describe(ch chan<- *prometheus.Desc) {
+	o.requestsTotal.Describe(ch)
+	o.requestDuration.Describe(ch)
+	o.activeSessions.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (o *Observer) Collect(ch chan<- prometheus.Metric) {
+	o.requestsTotal.Collect(ch)
+	o.requestDuration.Collect(ch)
+	o.activeSessions.Collect(ch)
+}
+
+// OnRequestStart implements confd.Observer.
+func (o *Observer) OnRequestStart(id uint64, method string) {}
+
+// OnRequestEnd implements confd.Observer.
+func (o *Observer) OnRequestEnd(id uint64, method string, err error, dur time.Duration) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	o.requestsTotal.WithLabelValues(method, status).Inc()
+	o.requestDuration.WithLabelValues(method).Observe(dur.Seconds())
+}
+
+// OnConnect implements confd.Observer.
+func (o *Observer) OnConnect() {
+	o.activeSessions.Inc()
+}
+
+// OnClose implements confd.Observer.
+func (o *Observer) OnClose() {
+	o.activeSessions.Dec()
+}
+
+var _ confd.Observer = (*Observer)(nil)
@@ -0,0 +1,340 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by Pool.Get once the pool has been closed.
+var ErrPoolClosed = errors.New("confd: pool closed")
+
+const defaultHealthCheckInterval = 30 * time.Second
+
+// Pool maintains up to size confd sessions against url, handing them out
+// to callers via Get/Put (or the Request convenience) instead of every
+// caller serializing RPCs through a single Conn's requestMu. A Conn used
+// on its own, as returned by NewConn, remains a perfectly valid
+// single-session client; Pool is the opt-in layer for callers that want to
+// exploit concurrency.
+type Pool struct {
+	URL         string
+	maxOpen     int
+	maxIdle     int
+	maxLifetime time.Duration
+	healthCheck time.Duration
+
+	configure func(*Conn)
+
+	mu         sync.Mutex
+	idle       []*Conn
+	createdAt  map[*Conn]time.Time
+	numOpen    int
+	waiters    []chan *Conn
+	closed     bool
+	stopHealth chan struct{}
+
+	waitCount    int64
+	waitDuration time.Duration
+}
+
+// PoolOption configures a Pool created by NewPool.
+type PoolOption func(*Pool)
+
+// WithMaxIdle caps the number of idle sessions kept around for reuse;
+// excess sessions are closed instead of returned to the pool. Defaults to
+// size.
+func WithMaxIdle(n int) PoolOption {
+	return func(p *Pool) { p.maxIdle = n }
+}
+
+// WithMaxLifetime closes and replaces a session once it has been open
+// longer than d, regardless of how it has been used. Zero (the default)
+// means sessions live until a health check or request fails.
+func WithMaxLifetime(d time.Duration) PoolOption {
+	return func(p *Pool) { p.maxLifetime = d }
+}
+
+// WithHealthCheckInterval sets how often idle sessions are probed with a
+// lightweight get_SID call; a failing session is closed and its slot freed
+// for a fresh one. Zero disables health checks.
+func WithHealthCheckInterval(d time.Duration) PoolOption {
+	return func(p *Pool) { p.healthCheck = d }
+}
+
+// WithConnConfigurator calls configure on every Conn the pool opens, before
+// it is handed out for the first time, so pooled sessions can be wired up
+// with the same Logger, Observers, RetryPolicy, Timeout and SensitiveKeys a
+// standalone Conn would get.
+func WithConnConfigurator(configure func(*Conn)) PoolOption {
+	return func(p *Pool) { p.configure = configure }
+}
+
+// NewPool creates a Pool of at most size confd sessions against url.
+// Sessions are opened lazily, on first use.
+func NewPool(url string, size int, opts ...PoolOption) (*Pool, error) {
+	if size <= 0 {
+		return nil, errors.New("confd: pool size must be > 0")
+	}
+
+	p := &Pool{
+		URL:         url,
+		maxOpen:     size,
+		maxIdle:     size,
+		healthCheck: defaultHealthCheckInterval,
+		createdAt:   make(map[*Conn]time.Time),
+		stopHealth:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.healthCheck > 0 {
+		go p.healthCheckLoop()
+	}
+	return p, nil
+}
+
+// Get borrows a session from the pool, opening a new one if fewer than
+// size are open yet, or waiting for one to be returned via Put otherwise.
+// It honors ctx cancellation while waiting. The caller must return the
+// session with Put once done with it.
+func (p *Pool) Get(ctx context.Context) (*Conn, error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		n := len(p.idle)
+		if n == 0 {
+			break
+		}
+		conn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		if !p.withinLifetime(conn) {
+			// Evict instead of handing out a session past MaxLifetime,
+			// same as Put already does when it's returned.
+			delete(p.createdAt, conn)
+			p.numOpen--
+			p.mu.Unlock()
+			_ = conn.Close()
+			p.mu.Lock()
+			continue
+		}
+		p.mu.Unlock()
+		return conn, nil
+	}
+	if p.numOpen < p.maxOpen {
+		p.numOpen++
+		p.mu.Unlock()
+		conn, err := NewConn(p.URL)
+		if err != nil {
+			p.mu.Lock()
+			p.numOpen--
+			p.mu.Unlock()
+			return nil, err
+		}
+		if p.configure != nil {
+			p.configure(conn)
+		}
+		p.mu.Lock()
+		p.createdAt[conn] = time.Now()
+		p.mu.Unlock()
+		return conn, nil
+	}
+
+	// pool exhausted, wait for a Put
+	wait := make(chan *Conn, 1)
+	p.waiters = append(p.waiters, wait)
+	p.waitCount++
+	p.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		p.mu.Lock()
+		p.waitDuration += time.Since(start)
+		p.mu.Unlock()
+	}()
+	select {
+	case conn, ok := <-wait:
+		if !ok {
+			return nil, ErrPoolClosed
+		}
+		return conn, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		for i, w := range p.waiters {
+			if w == wait {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				break
+			}
+		}
+		p.mu.Unlock()
+		// A Put may have raced us and already delivered a session into wait
+		// just as ctx expired; drain it without blocking so it isn't
+		// silently leaked, and hand it back to the pool.
+		select {
+		case conn, ok := <-wait:
+			if ok {
+				p.Put(conn)
+			}
+		default:
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Put returns conn to the pool. A session that has exceeded MaxLifetime is
+// closed instead of reused; otherwise it is handed to a waiting Get or
+// kept idle, up to MaxIdle, before being closed.
+func (p *Pool) Put(conn *Conn) {
+	p.mu.Lock()
+	if p.closed || !p.withinLifetime(conn) {
+		delete(p.createdAt, conn)
+		p.numOpen--
+		p.mu.Unlock()
+		_ = conn.Close()
+		return
+	}
+	if n := len(p.waiters); n > 0 {
+		wait := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		p.mu.Unlock()
+		wait <- conn
+		return
+	}
+	if len(p.idle) >= p.maxIdle {
+		delete(p.createdAt, conn)
+		p.numOpen--
+		p.mu.Unlock()
+		_ = conn.Close()
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
+
+func (p *Pool) withinLifetime(conn *Conn) bool {
+	if p.maxLifetime <= 0 {
+		return true
+	}
+	return time.Since(p.createdAt[conn]) < p.maxLifetime
+}
+
+// Request borrows a session, issues method through RequestContext, and
+// returns the session to the pool. It re-connects automatically: if the
+// borrowed session's transport was closed by a previous failure, the next
+// RequestContext call re-authenticates it, the same way it would for a
+// standalone Conn.
+func (p *Pool) Request(ctx context.Context, method string, result interface{}, params ...interface{}) error {
+	conn, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Put(conn)
+	return conn.RequestContext(ctx, method, result, params...)
+}
+
+// Close closes every idle session and prevents further Get calls. Sessions
+// currently borrowed are closed as they are returned via Put.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	waiters := p.waiters
+	p.waiters = nil
+	p.mu.Unlock()
+
+	close(p.stopHealth)
+	for _, wait := range waiters {
+		close(wait)
+	}
+
+	var err error
+	for _, conn := range idle {
+		if cerr := conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// PoolStats is a point-in-time snapshot of a Pool, modeled on
+// database/sql.DB.Stats.
+type PoolStats struct {
+	MaxOpen      int           // maximum number of sessions the pool will open
+	OpenSessions int           // sessions currently open, idle or in use
+	InUse        int           // sessions currently borrowed via Get
+	Idle         int           // sessions open and idle
+	WaitCount    int64         // total number of Get calls that had to wait
+	WaitDuration time.Duration // total time spent waiting for a session
+}
+
+// Stats returns a snapshot of the pool's current state.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{
+		MaxOpen:      p.maxOpen,
+		OpenSessions: p.numOpen,
+		InUse:        p.numOpen - len(p.idle),
+		Idle:         len(p.idle),
+		WaitCount:    p.waitCount,
+		WaitDuration: p.waitDuration,
+	}
+}
+
+// healthCheckLoop periodically probes idle sessions with a lightweight
+// get_SID call, closing and discarding any that fail so the next Get opens
+// a fresh one in its place.
+func (p *Pool) healthCheckLoop() {
+	ticker := time.NewTicker(p.healthCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopHealth:
+			return
+		case <-ticker.C:
+			p.checkIdle()
+		}
+	}
+}
+
+func (p *Pool) checkIdle() {
+	p.mu.Lock()
+	idle := make([]*Conn, len(p.idle))
+	copy(idle, p.idle)
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		err := conn.RequestContext(ctx, "get_SID", &conn.Options.SID)
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		p.mu.Lock()
+		for i, c := range p.idle {
+			if c == conn {
+				p.idle = append(p.idle[:i], p.idle[i+1:]...)
+				p.numOpen--
+				delete(p.createdAt, conn)
+				break
+			}
+		}
+		p.mu.Unlock()
+		_ = conn.Close()
+	}
+}
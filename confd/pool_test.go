@@ -0,0 +1,116 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const testPoolURL = "http://127.0.0.1:4472/"
+
+// TestPoolGetDoesNotLeakOnCtxRace exercises the race between a Get's ctx
+// expiring and a concurrent Put delivering a session to it: whichever way
+// the select falls, the session must end up either with the caller or back
+// in the pool, never stranded.
+func TestPoolGetDoesNotLeakOnCtxRace(t *testing.T) {
+	p, err := NewPool(testPoolURL, 1)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if got, err := p.Get(ctx); err == nil {
+				p.Put(got)
+			}
+		}()
+		time.Sleep(time.Millisecond) // let the goroutine register as a waiter
+		cancel()
+		p.Put(conn)
+		<-done
+
+		// Regardless of which branch of the race won, the lone session
+		// must be recoverable; a leaked session would make this Get block
+		// until the deadline and fail.
+		ctx2, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		conn, err = p.Get(ctx2)
+		cancel2()
+		if err != nil {
+			t.Fatalf("iteration %d: session leaked, Get timed out: %v", i, err)
+		}
+	}
+	p.Put(conn)
+}
+
+// TestPoolGetEvictsExpiredIdleSession guards against Get handing out an
+// idle session that has already outlived MaxLifetime; only Put checked
+// this before, so a session could be reused for an entire extra borrow
+// past its lifetime.
+func TestPoolGetEvictsExpiredIdleSession(t *testing.T) {
+	p, err := NewPool(testPoolURL, 1, WithMaxLifetime(time.Minute))
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.Put(conn) // back to idle
+
+	p.mu.Lock()
+	p.createdAt[conn] = time.Now().Add(-time.Hour)
+	p.mu.Unlock()
+
+	conn2, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer p.Put(conn2)
+
+	if conn2 == conn {
+		t.Errorf("Get returned a session past MaxLifetime instead of evicting it")
+	}
+	if stats := p.Stats(); stats.OpenSessions != 1 {
+		t.Errorf("OpenSessions = %d, want 1 (expired session evicted, fresh one opened)", stats.OpenSessions)
+	}
+}
+
+func TestPoolWithConnConfigurator(t *testing.T) {
+	var configured []*Conn
+	p, err := NewPool(testPoolURL, 1, WithConnConfigurator(func(c *Conn) {
+		c.Timeout = 5 * time.Second
+		configured = append(configured, c)
+	}))
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer p.Put(conn)
+
+	if conn.Timeout != 5*time.Second {
+		t.Errorf("Timeout = %v, want 5s", conn.Timeout)
+	}
+	if len(configured) != 1 || configured[0] != conn {
+		t.Errorf("configure callback not invoked exactly once for the new conn")
+	}
+}
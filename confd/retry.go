@@ -0,0 +1,71 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy controls how RequestContext (and Request, which calls it with
+// context.Background()) retries a call that failed with a retryable error
+// (ErrEmptyResponse, a connection reset, or any net.Error from the
+// Transport). Retries use exponential backoff with full jitter: each delay
+// is drawn uniformly from zero up to BaseDelay doubled once per previous
+// retry, capped at MaxDelay. Retries stop early if the context is done.
+type RetryPolicy struct {
+	MaxRetries int           // number of retries after the initial attempt
+	BaseDelay  time.Duration // upper bound of the jittered delay before the first retry
+	MaxDelay   time.Duration // upper bound for the backoff delay
+}
+
+// DefaultRetryPolicy retries up to 3 times with a delay growing from 100ms
+// to at most 2s.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  100 * time.Millisecond,
+	MaxDelay:   2 * time.Second,
+}
+
+// backoff returns a jittered delay for the given retry attempt (0-based).
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay * time.Duration(uint64(1)<<uint(attempt))
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// isRetryableError reports whether err is transient and worth retrying:
+// an empty confd response, a connection reset, or a transport-level
+// net.Error (timeouts, refused connections, ...).
+func isRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		// The context is done; context.DeadlineExceeded satisfies net.Error
+		// (it implements Timeout()/Temporary()), which would otherwise make an expired
+		// deadline look retryable, burning the rest of the retry budget on
+		// calls that cannot possibly succeed.
+		return false
+	}
+	if errors.Is(err, ErrEmptyResponse) {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.ErrClosedPipe) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
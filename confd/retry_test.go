@@ -0,0 +1,32 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestIsRetryableErrorExcludesCtxErrors(t *testing.T) {
+	cases := []error{
+		context.DeadlineExceeded,
+		context.Canceled,
+		fmt.Errorf("wrapped: %w", context.DeadlineExceeded),
+	}
+	for _, err := range cases {
+		if isRetryableError(err) {
+			t.Errorf("isRetryableError(%v) = true, want false", err)
+		}
+	}
+}
+
+func TestIsRetryableErrorNetError(t *testing.T) {
+	err := &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}
+	if !isRetryableError(err) {
+		t.Errorf("isRetryableError(%v) = false, want true", err)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// confdSocketAddr is the address the confd daemon listens on inside the UTM,
+// reachable only from 127.0.0.1. SSHTransport forwards it through a
+// direct-tcpip channel instead of requiring a manually established
+// `ssh -L 4472:127.0.0.1:4472` tunnel.
+const confdSocketAddr = "127.0.0.1:4472"
+
+// ErrSSHNotConnected is returned when a RoundTrip is attempted before the
+// SSH transport established a channel to the confd socket.
+var ErrSSHNotConnected = errors.New("confd: ssh transport not connected")
+
+// SSHTransport is a Transport that reaches confd through an SSH connection,
+// opening a direct-tcpip channel to confdSocketAddr and using it as the
+// underlying net.Conn for the JSON-RPC round trips. It replaces the manual
+// `ssh -L 4472:127.0.0.1:4472` tunnel previously required before calling
+// NewConn.
+type SSHTransport struct {
+	Addr            string              // address of the SSH server, e.g. "utm:22"
+	User            string              // SSH user
+	Auth            ssh.AuthMethod      // SSH authentication method
+	HostKeyCallback ssh.HostKeyCallback // verifies the SSH host key
+
+	mu     sync.Mutex
+	client *ssh.Client // shared SSH session, reused across RoundTrip calls
+	conn   net.Conn    // direct-tcpip channel to confdSocketAddr
+}
+
+// Connect dials the SSH server (if not already connected) and opens a
+// direct-tcpip channel to confdSocketAddr that RoundTrip will use.
+func (t *SSHTransport) Connect(u *url.URL) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client == nil {
+		config := &ssh.ClientConfig{
+			User:            t.User,
+			Auth:            []ssh.AuthMethod{t.Auth},
+			HostKeyCallback: t.HostKeyCallback,
+		}
+		client, err := ssh.Dial("tcp", t.Addr, config)
+		if err != nil {
+			return fmt.Errorf("confd: ssh dial %s: %w", t.Addr, err)
+		}
+		t.client = client
+	}
+
+	conn, err := t.client.Dial("tcp", confdSocketAddr)
+	if err != nil {
+		_ = t.client.Close()
+		t.client = nil
+		return fmt.Errorf("confd: open direct-tcpip channel to %s: %w", confdSocketAddr, err)
+	}
+	t.conn = conn
+	return nil
+}
+
+// IsConnected reports whether a direct-tcpip channel is currently open.
+func (t *SSHTransport) IsConnected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn != nil
+}
+
+// RoundTrip writes req to the direct-tcpip channel and reads back the
+// confd response, reusing the same SSH session for every call. It honors
+// req.Context(): a deadline on the context bounds the whole round trip,
+// and the channel's deadline is set to now as soon as the context is done,
+// unblocking any in-flight read or write; the channel itself stays open.
+func (t *SSHTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
+		return nil, ErrSSHNotConnected
+	}
+
+	ctx := req.Context()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = t.conn.SetDeadline(deadline)
+		defer t.conn.SetDeadline(time.Time{})
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			_ = t.conn.SetDeadline(time.Now()) // unblock any in-flight read/write
+		case <-done:
+		}
+	}()
+	// Wait for the goroutine above to actually observe done (or have
+	// already fired SetDeadline(time.Now()) on ctx.Done()) before the
+	// deadline-reset defer above runs; otherwise a ctx expiring at nearly
+	// the same instant as this RoundTrip completes can poison the next
+	// RoundTrip's read/write with a bogus immediate timeout.
+	defer func() {
+		close(done)
+		<-exited
+	}()
+
+	if err := req.Write(t.conn); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(t.conn), req)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return resp, err
+}
+
+// Close closes the direct-tcpip channel as well as the underlying SSH
+// session.
+func (t *SSHTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var err error
+	if t.conn != nil {
+		err = t.conn.Close()
+		t.conn = nil
+	}
+	if t.client != nil {
+		if cerr := t.client.Close(); err == nil {
+			err = cerr
+		}
+		t.client = nil
+	}
+	return err
+}
+
+// NewSSHConn creates a new confd connection that reaches confd through an
+// SSH tunnel, dialing sshAddr (e.g. "utm:22") and opening a direct-tcpip
+// channel to confdSocketAddr that carries the JSON-RPC round trips. The SSH session
+// is established lazily, on the first Request, and shared by all
+// subsequent calls. The connection is not actually established until the
+// first Request (see NewConn).
+func NewSSHConn(sshAddr, user string, auth ssh.AuthMethod, hostKeyCallback ssh.HostKeyCallback) (conn *Conn, err error) {
+	u, err := url.Parse(fmt.Sprintf("ssh+confd://%s@%s/", user, sshAddr))
+	if err != nil {
+		return
+	}
+
+	conn = &Conn{
+		URL:     u,
+		Options: newOptions(u),
+		Transport: &SSHTransport{
+			Addr:            sshAddr,
+			User:            user,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+		},
+	}
+	return
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNetConn is a net.Conn whose Read always returns a canned HTTP/1.1
+// response and whose SetDeadline calls are recorded, so tests can assert on
+// the final deadline RoundTrip leaves the connection in.
+type fakeNetConn struct {
+	mu        sync.Mutex
+	resp      *bytes.Reader
+	deadlines []time.Time
+}
+
+func newFakeNetConn() *fakeNetConn {
+	return &fakeNetConn{resp: bytes.NewReader([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))}
+}
+
+func (c *fakeNetConn) Read(p []byte) (int, error)       { return c.resp.Read(p) }
+func (c *fakeNetConn) Write(p []byte) (int, error)      { return len(p), nil }
+func (c *fakeNetConn) Close() error                     { return nil }
+func (c *fakeNetConn) LocalAddr() net.Addr              { return fakeAddr{} }
+func (c *fakeNetConn) RemoteAddr() net.Addr             { return fakeAddr{} }
+func (c *fakeNetConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *fakeNetConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *fakeNetConn) SetDeadline(deadline time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadlines = append(c.deadlines, deadline)
+	return nil
+}
+func (c *fakeNetConn) lastDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadlines[len(c.deadlines)-1]
+}
+
+type fakeAddr struct{}
+
+func (fakeAddr) Network() string { return "fake" }
+func (fakeAddr) String() string  { return "fake" }
+
+// lateDoneCtx carries a real deadline (so RoundTrip always calls
+// SetDeadline) but only closes Done once a test explicitly arms it, letting
+// the test fire ctx expiry at a chosen moment instead of relying on a real
+// clock to hit the race.
+type lateDoneCtx struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+}
+
+func newLateDoneCtx() *lateDoneCtx {
+	return &lateDoneCtx{
+		Context:  context.Background(),
+		deadline: time.Now().Add(time.Hour),
+		done:     make(chan struct{}),
+	}
+}
+func (c *lateDoneCtx) Done() <-chan struct{} { return c.done }
+func (c *lateDoneCtx) Err() error {
+	select {
+	case <-c.done:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+func (c *lateDoneCtx) Deadline() (time.Time, bool) { return c.deadline, true }
+
+// TestSSHTransportRoundTripResetsDeadlineDespiteLateCtxDone guards against
+// the cleanup goroutine's SetDeadline(time.Now()) firing after RoundTrip's
+// own deferred reset, which would poison the connection's deadline for the
+// next, reused RoundTrip.
+func TestSSHTransportRoundTripResetsDeadlineDespiteLateCtxDone(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		conn := newFakeNetConn()
+		tr := &SSHTransport{conn: conn}
+
+		ctx := newLateDoneCtx()
+		req, err := http.NewRequest(http.MethodPost, "http://confd/", nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req = req.WithContext(ctx)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			resp, err := tr.RoundTrip(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		// Fire Done right as RoundTrip is finishing its read, racing the
+		// cleanup goroutine against RoundTrip's own return.
+		close(ctx.done)
+		<-done
+
+		if got := conn.lastDeadline(); !got.IsZero() {
+			t.Fatalf("iteration %d: final deadline = %v, want zero (reset)", i, got)
+		}
+	}
+}